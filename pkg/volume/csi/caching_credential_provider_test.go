@@ -0,0 +1,162 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// fakeSecretNamespaceLister and fakeSecretLister back cachingCredentialProvider
+// tests with an in-memory stand-in for the informer's local store, without
+// needing to stand up a real informer and wait for it to sync.
+type fakeSecretNamespaceLister struct {
+	namespace string
+	secrets   map[string]*api.Secret // key: namespace/name
+}
+
+func (f *fakeSecretNamespaceLister) List(selector labels.Selector) ([]*api.Secret, error) {
+	var out []*api.Secret
+	for _, s := range f.secrets {
+		if s.Namespace == f.namespace {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeSecretNamespaceLister) Get(name string) (*api.Secret, error) {
+	if s, ok := f.secrets[f.namespace+"/"+name]; ok {
+		return s, nil
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+}
+
+type fakeSecretLister struct {
+	secrets map[string]*api.Secret // key: namespace/name
+}
+
+func newFakeSecretLister(secrets ...*api.Secret) *fakeSecretLister {
+	l := &fakeSecretLister{secrets: map[string]*api.Secret{}}
+	for _, s := range secrets {
+		l.secrets[s.Namespace+"/"+s.Name] = s
+	}
+	return l
+}
+
+func (f *fakeSecretLister) List(selector labels.Selector) ([]*api.Secret, error) {
+	var out []*api.Secret
+	for _, s := range f.secrets {
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (f *fakeSecretLister) Secrets(namespace string) corelisters.SecretNamespaceLister {
+	return &fakeSecretNamespaceLister{namespace: namespace, secrets: f.secrets}
+}
+
+// countingGetClientset wraps a fake clientset to count live Secrets().Get()
+// calls against the API server, distinct from reads served by the lister.
+func countingGetClientset(getCalls *int32, objs ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewSimpleClientset(objs...)
+	clientset.PrependReactor("get", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		atomic.AddInt32(getCalls, 1)
+		return false, nil, nil
+	})
+	return clientset
+}
+
+func TestCachingCredentialProviderHitAvoidsLiveGet(t *testing.T) {
+	secret := &api.Secret{
+		ObjectMeta: meta.ObjectMeta{Namespace: "ns", Name: "creds", ResourceVersion: "1"},
+		Data:       map[string][]byte{"user": []byte("alice")},
+	}
+	var getCalls int32
+	clientset := countingGetClientset(&getCalls, secret)
+	lister := newFakeSecretLister(secret)
+
+	sc, err := newSecretCache(time.Minute, 10)
+	if err != nil {
+		t.Fatalf("newSecretCache failed: %v", err)
+	}
+	provider := &cachingCredentialProvider{cache: sc, lister: lister}
+	secretRef := &api.SecretReference{Namespace: "ns", Name: "creds"}
+
+	if _, err := provider.GetCredentials(clientset, secretRef, nil); err != nil {
+		t.Fatalf("GetCredentials (miss) failed: %v", err)
+	}
+	// the informer's lister already holds the Secret, so even the miss is
+	// served without a live Get
+	if got := atomic.LoadInt32(&getCalls); got != 0 {
+		t.Fatalf("expected no live Get on a cache miss served by the lister, got %d", got)
+	}
+
+	credentials, err := provider.GetCredentials(clientset, secretRef, nil)
+	if err != nil {
+		t.Fatalf("GetCredentials (hit) failed: %v", err)
+	}
+	if credentials["user"] != "alice" {
+		t.Errorf("got %v, want user=alice", credentials)
+	}
+	if got := atomic.LoadInt32(&getCalls); got != 0 {
+		t.Errorf("expected a cache hit to make no live Get calls, saw %d total", got)
+	}
+}
+
+func TestCachingCredentialProviderFallsBackToLiveGetOnListerMiss(t *testing.T) {
+	secret := &api.Secret{
+		ObjectMeta: meta.ObjectMeta{Namespace: "ns", Name: "creds", ResourceVersion: "1"},
+		Data:       map[string][]byte{"user": []byte("alice")},
+	}
+	var getCalls int32
+	clientset := countingGetClientset(&getCalls, secret)
+	// the informer hasn't observed this Secret yet: empty lister
+	lister := newFakeSecretLister()
+
+	sc, err := newSecretCache(time.Minute, 10)
+	if err != nil {
+		t.Fatalf("newSecretCache failed: %v", err)
+	}
+	provider := &cachingCredentialProvider{cache: sc, lister: lister}
+	secretRef := &api.SecretReference{Namespace: "ns", Name: "creds"}
+
+	credentials, err := provider.GetCredentials(clientset, secretRef, nil)
+	if err != nil {
+		t.Fatalf("GetCredentials failed: %v", err)
+	}
+	if credentials["user"] != "alice" {
+		t.Errorf("got %v, want user=alice", credentials)
+	}
+	// the one live Get resolves the ResourceVersion via the lister fallback
+	// and yields the Secret's Data in the same object, so credentials are
+	// built from it directly without a second live call.
+	if got := atomic.LoadInt32(&getCalls); got != 1 {
+		t.Errorf("expected exactly 1 live Get call when the lister hasn't synced the Secret yet, got %d", got)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"fmt"
+	"sync"
+
+	api "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// credentialProviderAttribute is the CSI volume attribute used to select an
+// alternative CredentialProvider for resolving NodePublishSecretRef,
+// ControllerPublishSecretRef, NodeStageSecretRef, etc. When unset, the
+// built-in provider backed by the Kubernetes Secrets API is used.
+const credentialProviderAttribute = "csi.credentialProvider"
+
+// defaultCredentialProviderName is the name of the built-in provider that
+// resolves credentials via the Kubernetes Secrets API. It preserves the
+// historical behavior of getCredentialsFromSecret.
+const defaultCredentialProviderName = "secret"
+
+// CredentialProvider resolves the map[string]string that is sent to a CSI
+// driver as the `secrets` field of NodeStageVolumeRequest,
+// NodePublishVolumeRequest, ControllerPublishVolumeRequest, etc. In addition
+// to the default Kubernetes Secrets API, operators can register providers
+// that resolve credentials from a host path, an environment variable, or an
+// external KMS/Vault-style service.
+type CredentialProvider interface {
+	// GetCredentials returns the credential data referenced by secretRef.
+	// k8s is passed through for providers that still need API server
+	// access; volumeAttributes carries the CSI volume attributes so a
+	// provider can read additional, provider-specific configuration (for
+	// example a file path or endpoint).
+	GetCredentials(k8s kubernetes.Interface, secretRef *api.SecretReference, volumeAttributes map[string]string) (map[string]string, error)
+}
+
+var (
+	credentialProvidersLock sync.RWMutex
+	credentialProviders     = map[string]CredentialProvider{}
+)
+
+func init() {
+	RegisterCredentialProvider(defaultCredentialProviderName, &secretCredentialProvider{})
+}
+
+// RegisterCredentialProvider registers a CredentialProvider under name so it
+// can be selected per-PV by setting the csi.credentialProvider volume
+// attribute to name. It panics if name is already registered.
+func RegisterCredentialProvider(name string, provider CredentialProvider) {
+	credentialProvidersLock.Lock()
+	defer credentialProvidersLock.Unlock()
+	if _, found := credentialProviders[name]; found {
+		panic(fmt.Sprintf("csi: credential provider %q was registered twice", name))
+	}
+	credentialProviders[name] = provider
+}
+
+// getCredentialProvider returns the CredentialProvider selected via
+// volumeAttributes[csi.credentialProvider], falling back to the built-in
+// Kubernetes Secrets-backed provider when the attribute is unset.
+func getCredentialProvider(volumeAttributes map[string]string) (CredentialProvider, error) {
+	name := volumeAttributes[credentialProviderAttribute]
+	if name == "" {
+		name = defaultCredentialProviderName
+	}
+
+	credentialProvidersLock.RLock()
+	defer credentialProvidersLock.RUnlock()
+	provider, ok := credentialProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("csi: no credential provider registered for name %q", name)
+	}
+	return provider, nil
+}
+
+// secretCredentialProvider is the built-in CredentialProvider that resolves
+// credentials by reading a Kubernetes Secret referenced by secretRef.
+type secretCredentialProvider struct{}
+
+func (p *secretCredentialProvider) GetCredentials(k8s kubernetes.Interface, secretRef *api.SecretReference, volumeAttributes map[string]string) (map[string]string, error) {
+	secret, err := k8s.CoreV1().Secrets(secretRef.Namespace).Get(secretRef.Name, meta.GetOptions{})
+	if err != nil {
+		klog.Errorf("failed to find the secret %s in the namespace %s with error: %v\n", secretRef.Name, secretRef.Namespace, err)
+		return map[string]string{}, err
+	}
+	return credentialsFromSecret(secret), nil
+}
+
+// credentialsFromSecret flattens secret.Data into the map[string]string
+// shape CredentialProvider.GetCredentials returns.
+func credentialsFromSecret(secret *api.Secret) map[string]string {
+	credentials := map[string]string{}
+	for key, value := range secret.Data {
+		credentials[key] = string(value)
+	}
+	return credentials
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"testing"
+
+	api "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// fakeCredentialProvider is a CredentialProvider stub that returns a fixed
+// map without touching k8s, used only to tell which provider
+// getCredentialProvider actually resolved to.
+type fakeCredentialProvider struct {
+	credentials map[string]string
+}
+
+func (p *fakeCredentialProvider) GetCredentials(k8s kubernetes.Interface, secretRef *api.SecretReference, volumeAttributes map[string]string) (map[string]string, error) {
+	return p.credentials, nil
+}
+
+func TestGetCredentialProviderDefaultsToSecretProvider(t *testing.T) {
+	provider, err := getCredentialProvider(map[string]string{})
+	if err != nil {
+		t.Fatalf("getCredentialProvider failed: %v", err)
+	}
+	if _, ok := provider.(*secretCredentialProvider); !ok {
+		t.Errorf("expected the built-in secret provider when csi.credentialProvider is unset, got %T", provider)
+	}
+}
+
+func TestGetCredentialProviderSelectsByAttribute(t *testing.T) {
+	name := "test-fake-select"
+	fake := &fakeCredentialProvider{credentials: map[string]string{"k": "v"}}
+	RegisterCredentialProvider(name, fake)
+
+	provider, err := getCredentialProvider(map[string]string{credentialProviderAttribute: name})
+	if err != nil {
+		t.Fatalf("getCredentialProvider failed: %v", err)
+	}
+	if provider != CredentialProvider(fake) {
+		t.Errorf("expected the registered fake provider, got %T", provider)
+	}
+}
+
+func TestGetCredentialProviderUnknownName(t *testing.T) {
+	_, err := getCredentialProvider(map[string]string{credentialProviderAttribute: "does-not-exist"})
+	if err == nil {
+		t.Fatalf("expected an error for an unregistered credential provider name")
+	}
+}
+
+func TestRegisterCredentialProviderPanicsOnDuplicate(t *testing.T) {
+	name := "test-fake-duplicate"
+	RegisterCredentialProvider(name, &fakeCredentialProvider{})
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected RegisterCredentialProvider to panic when %q is already registered", name)
+		}
+	}()
+	RegisterCredentialProvider(name, &fakeCredentialProvider{})
+}
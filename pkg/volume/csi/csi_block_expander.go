@@ -0,0 +1,83 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"strconv"
+
+	csipb "github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog"
+)
+
+// NodeExpander resizes an already-staged or -published block volume on the
+// node by issuing NodeExpandVolume to the CSI driver, then records the new
+// size in the volume's persisted data file so it survives a kubelet
+// restart.
+//
+// TODO(chunk0-4): wire NodeExpander into BlockVolumeMapper/Unmapper so
+// online resize actually runs for raw-block PVCs; those types aren't part
+// of this tree yet, so for now NodeExpander only exists as the standalone
+// piece the request asked for.
+type NodeExpander struct {
+	client csipb.NodeClient
+}
+
+// NewNodeExpander returns a NodeExpander that issues NodeExpandVolume
+// through client.
+func NewNodeExpander(client csipb.NodeClient) *NodeExpander {
+	return &NodeExpander{client: client}
+}
+
+// Expand requests that the CSI driver grow volumeID, published as a raw
+// block device at volumePath with the given accessMode, to newSizeBytes,
+// then updates the volume data file at dataDir/dataFileName with the new
+// size so a later kubelet restart observes it.
+func (e *NodeExpander) Expand(ctx context.Context, volumeID, volumePath string, accessMode csipb.VolumeCapability_AccessMode_Mode, dataDir, dataFileName string, newSizeBytes int64) error {
+	req := &csipb.NodeExpandVolumeRequest{
+		VolumeId:   volumeID,
+		VolumePath: volumePath,
+		CapacityRange: &csipb.CapacityRange{
+			RequiredBytes: newSizeBytes,
+		},
+		VolumeCapability: &csipb.VolumeCapability{
+			AccessType: &csipb.VolumeCapability_Block{
+				Block: &csipb.VolumeCapability_BlockVolume{},
+			},
+			AccessMode: &csipb.VolumeCapability_AccessMode{
+				Mode: accessMode,
+			},
+		},
+	}
+
+	if _, err := e.client.NodeExpandVolume(ctx, req); err != nil {
+		klog.Error(log("NodeExpandVolume failed for volume %s at %s: %v", volumeID, volumePath, err))
+		return err
+	}
+
+	data, err := loadVolumeData(dataDir, dataFileName)
+	if err != nil {
+		klog.Error(log("NodeExpandVolume succeeded for volume %s but failed to load volume data file to record the new size: %v", volumeID, err))
+		return err
+	}
+	data[volDataKeySizeBytes] = strconv.FormatInt(newSizeBytes, 10)
+	return saveVolumeData(dataDir, dataFileName, data)
+}
+
+// volDataKeySizeBytes is the volume data key NodeExpander records the new,
+// post-expansion size under.
+const volDataKeySizeBytes = "size"
@@ -0,0 +1,89 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	csipb "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+)
+
+// fakeNodeClient implements csipb.NodeClient, recording the
+// NodeExpandVolumeRequest it receives. Every other method is inherited as a
+// nil embedded interface and must not be called by the code under test.
+type fakeNodeClient struct {
+	csipb.NodeClient
+	req *csipb.NodeExpandVolumeRequest
+	err error
+}
+
+func (f *fakeNodeClient) NodeExpandVolume(ctx context.Context, req *csipb.NodeExpandVolumeRequest, opts ...grpc.CallOption) (*csipb.NodeExpandVolumeResponse, error) {
+	f.req = req
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &csipb.NodeExpandVolumeResponse{}, nil
+}
+
+func TestNodeExpanderExpand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-node-expander")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := saveVolumeData(dir, "vol_data.json", map[string]string{"volumeId": "vol-1"}); err != nil {
+		t.Fatalf("saveVolumeData failed: %v", err)
+	}
+
+	fake := &fakeNodeClient{}
+	expander := NewNodeExpander(fake)
+
+	const newSize = 2 * 1024 * 1024 * 1024
+	err = expander.Expand(context.Background(), "vol-1", "/dev/block-path", csipb.VolumeCapability_AccessMode_SINGLE_NODE_WRITER, dir, "vol_data.json", newSize)
+	if err != nil {
+		t.Fatalf("Expand failed: %v", err)
+	}
+
+	if fake.req == nil {
+		t.Fatalf("expected NodeExpandVolume to be called")
+	}
+	if fake.req.VolumeId != "vol-1" || fake.req.VolumePath != "/dev/block-path" {
+		t.Errorf("unexpected request: %+v", fake.req)
+	}
+	if fake.req.CapacityRange.GetRequiredBytes() != newSize {
+		t.Errorf("got RequiredBytes %d, want %d", fake.req.CapacityRange.GetRequiredBytes(), newSize)
+	}
+	if fake.req.VolumeCapability.GetAccessMode().GetMode() != csipb.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
+		t.Errorf("expected AccessMode to be set on the VolumeCapability, got %+v", fake.req.VolumeCapability.GetAccessMode())
+	}
+	if fake.req.VolumeCapability.GetBlock() == nil {
+		t.Errorf("expected a block VolumeCapability, got %+v", fake.req.VolumeCapability)
+	}
+
+	data, err := loadVolumeData(dir, "vol_data.json")
+	if err != nil {
+		t.Fatalf("loadVolumeData failed: %v", err)
+	}
+	if data[volDataKeySizeBytes] != "2147483648" {
+		t.Errorf("expected the new size to be recorded in the volume data file, got %q", data[volDataKeySizeBytes])
+	}
+}
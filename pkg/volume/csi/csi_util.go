@@ -17,9 +17,7 @@ limitations under the License.
 package csi
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
 	"path"
 	"reflect"
 	"strings"
@@ -28,7 +26,7 @@ import (
 
 	csipb "github.com/container-storage-interface/spec/lib/go/csi"
 	api "k8s.io/api/core/v1"
-	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 	kstrings "k8s.io/kubernetes/pkg/util/strings"
@@ -44,57 +42,17 @@ const (
 	testInformerSyncTimeout = 30 * time.Second
 )
 
-func getCredentialsFromSecret(k8s kubernetes.Interface, secretRef *api.SecretReference) (map[string]string, error) {
-	credentials := map[string]string{}
-	secret, err := k8s.CoreV1().Secrets(secretRef.Namespace).Get(secretRef.Name, meta.GetOptions{})
+// getCredentialsFromSecret resolves the secret data for secretRef through the
+// CredentialProvider selected by volumeAttributes[csi.credentialProvider]
+// (the Kubernetes Secrets API by default), so it can be passed to the CSI
+// driver as the `secrets` field of a NodeStageVolumeRequest,
+// NodePublishVolumeRequest, ControllerPublishVolumeRequest, etc.
+func getCredentialsFromSecret(k8s kubernetes.Interface, secretRef *api.SecretReference, volumeAttributes map[string]string) (map[string]string, error) {
+	provider, err := getCredentialProvider(volumeAttributes)
 	if err != nil {
-		klog.Errorf("failed to find the secret %s in the namespace %s with error: %v\n", secretRef.Name, secretRef.Namespace, err)
-		return credentials, err
-	}
-	for key, value := range secret.Data {
-		credentials[key] = string(value)
-	}
-
-	return credentials, nil
-}
-
-// saveVolumeData persists parameter data as json file at the provided location
-func saveVolumeData(dir string, fileName string, data map[string]string) error {
-	dataFilePath := path.Join(dir, fileName)
-	klog.V(4).Info(log("saving volume data file [%s]", dataFilePath))
-	file, err := os.Create(dataFilePath)
-	if err != nil {
-		klog.Error(log("failed to save volume data file %s: %v", dataFilePath, err))
-		return err
-	}
-	defer file.Close()
-	if err := json.NewEncoder(file).Encode(data); err != nil {
-		klog.Error(log("failed to save volume data file %s: %v", dataFilePath, err))
-		return err
-	}
-	klog.V(4).Info(log("volume data file saved successfully [%s]", dataFilePath))
-	return nil
-}
-
-// loadVolumeData loads volume info from specified json file/location
-func loadVolumeData(dir string, fileName string) (map[string]string, error) {
-	// remove /mount at the end
-	dataFileName := path.Join(dir, fileName)
-	klog.V(4).Info(log("loading volume data file [%s]", dataFileName))
-
-	file, err := os.Open(dataFileName)
-	if err != nil {
-		klog.Error(log("failed to open volume data file [%s]: %v", dataFileName, err))
-		return nil, err
-	}
-	defer file.Close()
-	data := map[string]string{}
-	if err := json.NewDecoder(file).Decode(&data); err != nil {
-		klog.Error(log("failed to parse volume data file [%s]: %v", dataFileName, err))
 		return nil, err
 	}
-
-	return data, nil
+	return provider.GetCredentials(k8s, secretRef, volumeAttributes)
 }
 
 func getCSISourceFromSpec(spec *volume.Spec) (*api.CSIPersistentVolumeSource, error) {
@@ -136,6 +94,22 @@ func getVolumeDeviceDataDir(specVolID string, host volume.VolumeHost) string {
 	return path.Join(host.GetVolumeDevicePluginDir(csiPluginName), sanitizedSpecVolID, "data")
 }
 
+// getVolumeDeviceStagingPath returns the CSI 1.x staging path for a block
+// device associated with a given specVolumeID.
+// path: plugins/kubernetes.io/csi/volumeDevices/staging/{specVolumeID}
+func getVolumeDeviceStagingPath(specVolID string, host volume.VolumeHost) string {
+	sanitizedSpecVolID := kstrings.EscapeQualifiedNameForDisk(specVolID)
+	return path.Join(host.GetVolumeDevicePluginDir(csiPluginName), "staging", sanitizedSpecVolID)
+}
+
+// getVolumeDevicePublishPath returns the CSI 1.x publish path for a block
+// device associated with a given specVolumeID and podUID.
+// path: plugins/kubernetes.io/csi/volumeDevices/publish/{specVolumeID}/{podUID}
+func getVolumeDevicePublishPath(specVolID string, podUID types.UID, host volume.VolumeHost) string {
+	sanitizedSpecVolID := kstrings.EscapeQualifiedNameForDisk(specVolID)
+	return path.Join(host.GetVolumeDevicePluginDir(csiPluginName), "publish", sanitizedSpecVolID, string(podUID))
+}
+
 // hasReadWriteOnce returns true if modes contains v1.ReadWriteOnce
 func hasReadWriteOnce(modes []api.PersistentVolumeAccessMode) bool {
 	if modes == nil {
@@ -149,53 +123,200 @@ func hasReadWriteOnce(modes []api.PersistentVolumeAccessMode) bool {
 	return false
 }
 
-// SanitizeMsg scans proto message for map[string]string marked with csi_secret
-// amd replaces key's value with "* * * Sanitized * * *"
-func SanitizeMsg(pb interface{}) string {
-	if _, ok := pb.(descriptor.Message); !ok {
-		return ""
+// sanitizedPlaceholder replaces the value of any field marked csi_secret.
+const sanitizedPlaceholder = "***"
+
+// SanitizeMsg returns a copy of pb with every field annotated csi_secret
+// redacted, found by recursively walking pb's messages, repeated fields and
+// map fields -- not just pb's own top-level fields. pb itself is never
+// modified: the walk operates on a proto.Clone. The sanitized message is
+// returned both as a proto.Message, for callers that pass it on, and as its
+// formatted string, for logging.
+func SanitizeMsg(pb interface{}) (proto.Message, string) {
+	msg, ok := pb.(proto.Message)
+	if !ok {
+		return nil, ""
 	}
 
-	_, md := descriptor.ForMessage(pb.(descriptor.Message))
-	fields := md.GetField()
-	if fields == nil {
-		return ""
+	clone := proto.Clone(msg)
+	sanitizeMessage(clone, map[proto.Message]bool{})
+	return clone, fmt.Sprintf("%v", clone)
+}
+
+// sanitizeMessage redacts, in place, every field of msg -- and of any
+// message msg contains, directly or through a repeated or map field -- that
+// is annotated with the csi_secret extension. seen guards against infinite
+// recursion on self-referential message graphs.
+func sanitizeMessage(msg proto.Message, seen map[proto.Message]bool) {
+	if msg == nil || seen[msg] {
+		return
+	}
+	seen[msg] = true
+
+	dm, ok := msg.(descriptor.Message)
+	if !ok {
+		return
+	}
+	_, md := descriptor.ForMessage(dm)
+	if md == nil {
+		return
+	}
+
+	elem := reflect.ValueOf(msg)
+	if elem.Kind() != reflect.Ptr || elem.IsNil() {
+		return
+	}
+	elem = elem.Elem()
+
+	// Group the fields that belong to a oneof by their OneofIndex: on the
+	// generated Go struct they aren't reachable as direct fields (only the
+	// active variant is, nested inside a wrapper type behind a single
+	// interface field named after the oneof itself), so they need to be
+	// handled together rather than one FieldDescriptorProto at a time.
+	oneofMembers := map[int32][]*descr.FieldDescriptorProto{}
+	for _, field := range md.GetField() {
+		if field.OneofIndex != nil {
+			idx := field.GetOneofIndex()
+			oneofMembers[idx] = append(oneofMembers[idx], field)
+		}
 	}
-	sanitizeFields := []descr.FieldDescriptorProto{}
-	for _, field := range fields {
-		opt, err := proto.GetExtension(field.Options, csipb.E_CsiSecret)
-		if err == nil {
-			_, ok := opt.(*bool)
-			if ok {
-				sanitizeFields = append(sanitizeFields, *field)
-				break
+
+	handledOneofs := map[int32]bool{}
+	for _, field := range md.GetField() {
+		if field.OneofIndex != nil {
+			idx := field.GetOneofIndex()
+			if handledOneofs[idx] {
+				continue
 			}
+			handledOneofs[idx] = true
+			oneofDecl := md.GetOneofDecl()[idx]
+			sanitizeOneofField(elem, protoFieldToGoName(oneofDecl.GetName()), oneofMembers[idx], seen)
+			continue
+		}
+
+		fv := elem.FieldByName(protoFieldToGoName(field.GetName()))
+		if !fv.IsValid() {
+			continue
+		}
+
+		if isSecretField(field) {
+			redactValue(fv)
+			continue
 		}
+
+		// field isn't itself marked csi_secret, but csi_secret may be set
+		// deeper in the tree (e.g. on a field of a nested VolumeCapability
+		// or VolumeContentSource), so keep walking.
+		sanitizeNestedMessages(fv, seen)
 	}
-	if len(sanitizeFields) == 0 {
-		return ""
+}
+
+// sanitizeOneofField redacts or walks whichever oneof variant is actually
+// set in elem's wrapper field goFieldName (e.g. "AccessType" for the
+// "access_type" oneof). members are the FieldDescriptorProtos that share
+// that oneof, used to look up the csi_secret annotation of whichever
+// variant is active -- oneof members have no FieldDescriptorProto-matching
+// field directly on elem, only on the single-field wrapper struct behind
+// the interface.
+func sanitizeOneofField(elem reflect.Value, goFieldName string, members []*descr.FieldDescriptorProto, seen map[proto.Message]bool) {
+	wrapper := elem.FieldByName(goFieldName)
+	if !wrapper.IsValid() || wrapper.Kind() != reflect.Interface || wrapper.IsNil() {
+		return
 	}
-	msg, ok := pb.(proto.Message)
-	if !ok {
-		return ""
+
+	concrete := wrapper.Elem()
+	if concrete.Kind() != reflect.Ptr || concrete.IsNil() {
+		return
+	}
+	inner := concrete.Elem()
+	if inner.Kind() != reflect.Struct || inner.NumField() == 0 {
+		return
 	}
-	for _, field := range sanitizeFields {
-		fieldName := field.GetName()
-		fieldName = strings.ToUpper(fieldName[:1]) + fieldName[1:]
-		s := reflect.ValueOf(msg)
-		m, ok := reflect.Indirect(s).FieldByName(fieldName).Interface().(map[string]string)
-		if !ok {
-			return ""
+	memberValue := inner.Field(0)
+	memberGoName := inner.Type().Field(0).Name
+
+	for _, member := range members {
+		if protoFieldToGoName(member.GetName()) != memberGoName {
+			continue
 		}
-		for key := range m {
-			m[key] = "* * * Sanitized * * *"
+		if isSecretField(member) {
+			redactValue(memberValue)
+			return
 		}
-		if s.Elem().FieldByName(fieldName).CanSet() {
-			s.Elem().FieldByName(fieldName).Set(reflect.ValueOf(m))
-		} else {
-			return ""
+		break
+	}
+
+	sanitizeNestedMessages(memberValue, seen)
+}
+
+// isSecretField reports whether field carries the csi_secret extension set
+// to true.
+func isSecretField(field *descr.FieldDescriptorProto) bool {
+	opt, err := proto.GetExtension(field.Options, csipb.E_CsiSecret)
+	if err != nil {
+		return false
+	}
+	secret, ok := opt.(*bool)
+	return ok && secret != nil && *secret
+}
+
+// redactValue overwrites every string reachable from fv -- a bare string, or
+// the values of a map[string]string, or either of those nested in a repeated
+// field -- with sanitizedPlaceholder, leaving keys and slice length intact.
+func redactValue(fv reflect.Value) {
+	switch fv.Kind() {
+	case reflect.String:
+		if fv.CanSet() {
+			fv.SetString(sanitizedPlaceholder)
+		}
+	case reflect.Map:
+		for _, key := range fv.MapKeys() {
+			fv.SetMapIndex(key, reflect.ValueOf(sanitizedPlaceholder))
+		}
+	case reflect.Slice:
+		for i := 0; i < fv.Len(); i++ {
+			redactValue(fv.Index(i))
+		}
+	case reflect.Ptr:
+		if !fv.IsNil() {
+			redactValue(fv.Elem())
 		}
 	}
+}
 
-	return fmt.Sprintf("%v", msg)
+// sanitizeNestedMessages recurses into any proto.Message(s) reachable from
+// fv, whether fv holds a single message, a repeated field of messages, or a
+// map field whose values are messages.
+func sanitizeNestedMessages(fv reflect.Value, seen map[proto.Message]bool) {
+	switch fv.Kind() {
+	case reflect.Ptr:
+		if fv.IsNil() {
+			return
+		}
+		if nested, ok := fv.Interface().(proto.Message); ok {
+			sanitizeMessage(nested, seen)
+		}
+	case reflect.Slice:
+		for i := 0; i < fv.Len(); i++ {
+			sanitizeNestedMessages(fv.Index(i), seen)
+		}
+	case reflect.Map:
+		for _, key := range fv.MapKeys() {
+			sanitizeNestedMessages(fv.MapIndex(key), seen)
+		}
+	}
+}
+
+// protoFieldToGoName converts a protobuf field name (lower_snake or
+// lowerCamel, per the generated descriptor) to the exported Go struct field
+// name generated for it, e.g. "node_stage_secrets" -> "NodeStageSecrets".
+func protoFieldToGoName(protoFieldName string) string {
+	parts := strings.Split(protoFieldName, "_")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		parts[i] = strings.ToUpper(part[:1]) + part[1:]
+	}
+	return strings.Join(parts, "")
 }
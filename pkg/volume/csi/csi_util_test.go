@@ -0,0 +1,199 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"reflect"
+	"testing"
+
+	csipb "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	descr "github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+func TestSanitizeMsgNodeStageVolumeRequest(t *testing.T) {
+	req := &csipb.NodeStageVolumeRequest{
+		VolumeId: "test-vol",
+		Secrets: map[string]string{
+			"user": "alice",
+			"pass": "hunter2",
+		},
+		StagingTargetPath: "/var/lib/kubelet/staging",
+	}
+
+	sanitized, str := SanitizeMsg(req)
+	if sanitized == nil {
+		t.Fatalf("expected a sanitized message, got nil")
+	}
+	if str == "" {
+		t.Fatalf("expected a non-empty sanitized string")
+	}
+
+	out, ok := sanitized.(*csipb.NodeStageVolumeRequest)
+	if !ok {
+		t.Fatalf("expected *csipb.NodeStageVolumeRequest, got %T", sanitized)
+	}
+	for key, value := range out.Secrets {
+		if value != sanitizedPlaceholder {
+			t.Errorf("secret %s was not sanitized: got %q", key, value)
+		}
+	}
+	if out.VolumeId != req.VolumeId {
+		t.Errorf("non-secret field VolumeId was altered: got %q want %q", out.VolumeId, req.VolumeId)
+	}
+
+	// the original message must be left untouched
+	if req.Secrets["pass"] != "hunter2" {
+		t.Errorf("SanitizeMsg mutated the caller's message: Secrets[pass] = %q", req.Secrets["pass"])
+	}
+}
+
+func TestSanitizeMsgCreateVolumeRequest(t *testing.T) {
+	req := &csipb.CreateVolumeRequest{
+		Name: "test-vol",
+		Secrets: map[string]string{
+			"apiKey": "s3cr3t",
+		},
+		VolumeCapabilities: []*csipb.VolumeCapability{
+			{
+				AccessType: &csipb.VolumeCapability_Mount{
+					Mount: &csipb.VolumeCapability_MountVolume{FsType: "ext4"},
+				},
+				AccessMode: &csipb.VolumeCapability_AccessMode{
+					Mode: csipb.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+	}
+
+	sanitized, _ := SanitizeMsg(req)
+	out, ok := sanitized.(*csipb.CreateVolumeRequest)
+	if !ok {
+		t.Fatalf("expected *csipb.CreateVolumeRequest, got %T", sanitized)
+	}
+	for key, value := range out.Secrets {
+		if value != sanitizedPlaceholder {
+			t.Errorf("secret %s was not sanitized: got %q", key, value)
+		}
+	}
+	if len(out.VolumeCapabilities) != 1 || out.VolumeCapabilities[0].GetMount().GetFsType() != "ext4" {
+		t.Errorf("nested non-secret field VolumeCapabilities was altered: %+v", out.VolumeCapabilities)
+	}
+	if req.Secrets["apiKey"] != "s3cr3t" {
+		t.Errorf("SanitizeMsg mutated the caller's message: Secrets[apiKey] = %q", req.Secrets["apiKey"])
+	}
+}
+
+func TestSanitizeMsgControllerPublishVolumeRequest(t *testing.T) {
+	req := &csipb.ControllerPublishVolumeRequest{
+		VolumeId: "test-vol",
+		NodeId:   "node-1",
+		Secrets: map[string]string{
+			"token": "abc123",
+		},
+	}
+
+	sanitized, _ := SanitizeMsg(req)
+	out, ok := sanitized.(*csipb.ControllerPublishVolumeRequest)
+	if !ok {
+		t.Fatalf("expected *csipb.ControllerPublishVolumeRequest, got %T", sanitized)
+	}
+	for key, value := range out.Secrets {
+		if value != sanitizedPlaceholder {
+			t.Errorf("secret %s was not sanitized: got %q", key, value)
+		}
+	}
+	if out.NodeId != req.NodeId {
+		t.Errorf("non-secret field NodeId was altered: got %q want %q", out.NodeId, req.NodeId)
+	}
+}
+
+// testOneofVariant mimics the interface field generated for a protobuf
+// oneof; testOneofSecretVariant/testOneofPlainVariant mimic its two
+// single-field wrapper structs, reproducing the shape of e.g.
+// VolumeContentSource.Type or VolumeCapability.AccessType.
+type testOneofVariant interface {
+	isTestOneofVariant()
+}
+
+type testOneofHolder struct {
+	Variant testOneofVariant
+}
+
+type testOneofSecretVariant struct {
+	Secret map[string]string
+}
+
+func (*testOneofSecretVariant) isTestOneofVariant() {}
+
+type testOneofPlainVariant struct {
+	Plain string
+}
+
+func (*testOneofPlainVariant) isTestOneofVariant() {}
+
+// secretFieldDescriptor returns a FieldDescriptorProto annotated csi_secret,
+// as the generated CSI types carry on a field like NodeStageVolumeRequest's
+// "secrets".
+func secretFieldDescriptor(name string) *descr.FieldDescriptorProto {
+	field := &descr.FieldDescriptorProto{Name: proto.String(name), Options: &descr.FieldOptions{}}
+	if err := proto.SetExtension(field.Options, csipb.E_CsiSecret, proto.Bool(true)); err != nil {
+		panic(err)
+	}
+	return field
+}
+
+func TestSanitizeOneofFieldRedactsSecretVariant(t *testing.T) {
+	holder := &testOneofHolder{
+		Variant: &testOneofSecretVariant{Secret: map[string]string{"user": "alice"}},
+	}
+	members := []*descr.FieldDescriptorProto{
+		secretFieldDescriptor("secret"),
+		{Name: proto.String("plain"), Options: &descr.FieldOptions{}},
+	}
+
+	sanitizeOneofField(reflect.ValueOf(holder).Elem(), "Variant", members, map[proto.Message]bool{})
+
+	variant := holder.Variant.(*testOneofSecretVariant)
+	if variant.Secret["user"] != sanitizedPlaceholder {
+		t.Errorf("expected the secret field nested in the oneof variant to be redacted, got %q", variant.Secret["user"])
+	}
+}
+
+func TestSanitizeOneofFieldLeavesPlainVariantAlone(t *testing.T) {
+	holder := &testOneofHolder{
+		Variant: &testOneofPlainVariant{Plain: "unchanged"},
+	}
+	members := []*descr.FieldDescriptorProto{
+		secretFieldDescriptor("secret"),
+		{Name: proto.String("plain"), Options: &descr.FieldOptions{}},
+	}
+
+	sanitizeOneofField(reflect.ValueOf(holder).Elem(), "Variant", members, map[proto.Message]bool{})
+
+	variant := holder.Variant.(*testOneofPlainVariant)
+	if variant.Plain != "unchanged" {
+		t.Errorf("expected the non-secret oneof variant to be left alone, got %q", variant.Plain)
+	}
+}
+
+func TestSanitizeMsgNotAProtoMessage(t *testing.T) {
+	sanitized, str := SanitizeMsg("not a proto message")
+	if sanitized != nil || str != "" {
+		t.Errorf("expected (nil, \"\") for a non-proto.Message input, got (%v, %q)", sanitized, str)
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"k8s.io/klog"
+)
+
+const (
+	// volumeDataFileVersion is the current schema version written into the
+	// VolumeDataEnvelope.Version field by saveVolumeData. Bump it whenever
+	// the meaning of the Data payload changes in a way reconstruction code
+	// (e.g. csi_attacher.go/csi_mounter.go, rebuilding a volume.Spec on
+	// kubelet restart) needs to know about to decide whether to migrate.
+	volumeDataFileVersion = 1
+
+	volumeDataTmpSuffix    = ".tmp"
+	volumeDataBackupSuffix = ".bak"
+)
+
+// VolumeDataEnvelope is the on-disk schema saveVolumeData/loadVolumeData
+// persist volume data in. Version lets reconstruction code detect and
+// migrate older or newer layouts of Data; Checksum lets loadVolumeData
+// detect a file truncated by a kubelet crash mid-write.
+type VolumeDataEnvelope struct {
+	Version  int               `json:"version"`
+	Checksum string            `json:"checksum"`
+	Data     map[string]string `json:"data"`
+}
+
+// checksumVolumeData returns the sha256 checksum of data's canonical JSON
+// encoding, in the "sha256:<hex>" form stored in VolumeDataEnvelope.Checksum.
+func checksumVolumeData(data map[string]string) (string, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// saveVolumeData persists data as the checksummed, versioned envelope file
+// fileName in dir. The write is atomic: the envelope is encoded to a
+// temporary file which is fsynced and then renamed over fileName, and dir
+// itself is fsynced afterwards so the rename is durable across a crash. The
+// previous successful save, if any, is kept alongside it as fileName+".bak"
+// so loadVolumeData can recover from a save that is interrupted partway
+// through.
+func saveVolumeData(dir string, fileName string, data map[string]string) error {
+	dataFilePath := path.Join(dir, fileName)
+	klog.V(4).Info(log("saving volume data file [%s]", dataFilePath))
+
+	checksum, err := checksumVolumeData(data)
+	if err != nil {
+		klog.Error(log("failed to checksum volume data for file %s: %v", dataFilePath, err))
+		return err
+	}
+	envelope := VolumeDataEnvelope{
+		Version:  volumeDataFileVersion,
+		Checksum: checksum,
+		Data:     data,
+	}
+
+	tmpFilePath := dataFilePath + volumeDataTmpSuffix
+	if err := writeVolumeDataFile(tmpFilePath, &envelope); err != nil {
+		klog.Error(log("failed to save volume data file %s: %v", dataFilePath, err))
+		return err
+	}
+
+	if _, err := os.Stat(dataFilePath); err == nil {
+		if err := os.Rename(dataFilePath, dataFilePath+volumeDataBackupSuffix); err != nil {
+			klog.Error(log("failed to back up volume data file %s: %v", dataFilePath, err))
+			return err
+		}
+	}
+	if err := os.Rename(tmpFilePath, dataFilePath); err != nil {
+		klog.Error(log("failed to rename volume data file %s to %s: %v", tmpFilePath, dataFilePath, err))
+		return err
+	}
+	if err := fsyncDir(dir); err != nil {
+		klog.Error(log("failed to fsync volume data directory %s: %v", dir, err))
+		return err
+	}
+
+	klog.V(4).Info(log("volume data file saved successfully [%s]", dataFilePath))
+	return nil
+}
+
+// writeVolumeDataFile json-encodes envelope to filePath and fsyncs it before
+// closing, so its contents are durable once this call returns.
+func writeVolumeDataFile(filePath string, envelope *VolumeDataEnvelope) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(envelope); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// fsyncDir fsyncs dir itself, which most POSIX filesystems require in
+// addition to fsyncing a file in order for a preceding rename(2) within dir
+// to be durable.
+func fsyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// loadVolumeData loads and checksum-verifies the volume data envelope at
+// dir/fileName, returning its Data. If the primary file is missing or
+// corrupt -- the signature of a write interrupted by a kubelet crash -- it
+// falls back to the fileName+".bak" copy kept by the previous successful
+// saveVolumeData call.
+func loadVolumeData(dir string, fileName string) (map[string]string, error) {
+	dataFilePath := path.Join(dir, fileName)
+	klog.V(4).Info(log("loading volume data file [%s]", dataFilePath))
+
+	envelope, err := readVolumeDataFile(dataFilePath)
+	if err == nil {
+		return envelope.Data, nil
+	}
+	klog.Error(log("failed to load volume data file [%s]: %v, falling back to backup", dataFilePath, err))
+
+	backupFilePath := dataFilePath + volumeDataBackupSuffix
+	envelope, backupErr := readVolumeDataFile(backupFilePath)
+	if backupErr != nil {
+		klog.Error(log("failed to load backup volume data file [%s]: %v", backupFilePath, backupErr))
+		return nil, err
+	}
+	return envelope.Data, nil
+}
+
+// readVolumeDataFile decodes and checksum-verifies the envelope at filePath.
+// It also understands the pre-envelope on-disk format -- a bare
+// map[string]string, with no "version"/"checksum"/"data" wrapper at all --
+// written by kubelets from before this envelope was introduced, so a
+// kubelet upgrade doesn't stop volumes that already existed from loading.
+func readVolumeDataFile(filePath string) (*VolumeDataEnvelope, error) {
+	raw, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := &VolumeDataEnvelope{}
+	if err := json.Unmarshal(raw, envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse volume data file [%s]: %v", filePath, err)
+	}
+
+	if envelope.Data == nil {
+		// A legacy flat-map file has no "data" key, so decoding it into
+		// VolumeDataEnvelope above succeeds with Version == 0,
+		// Checksum == "" and Data == nil. Re-decode it as the plain map it
+		// actually is and skip checksum verification -- it predates the
+		// checksum entirely.
+		legacy := map[string]string{}
+		if err := json.Unmarshal(raw, &legacy); err != nil {
+			return nil, fmt.Errorf("failed to parse volume data file [%s]: %v", filePath, err)
+		}
+		klog.V(4).Info(log("volume data file [%s] is in the pre-envelope legacy format, reading it as version 0", filePath))
+		return &VolumeDataEnvelope{Data: legacy}, nil
+	}
+
+	if envelope.Version > volumeDataFileVersion {
+		klog.V(4).Info(log("volume data file [%s] has schema version %d, newer than %d -- reading Data as-is", filePath, envelope.Version, volumeDataFileVersion))
+	}
+
+	checksum, err := checksumVolumeData(envelope.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum volume data file [%s]: %v", filePath, err)
+	}
+	if checksum != envelope.Checksum {
+		return nil, fmt.Errorf("volume data file [%s] is corrupt: checksum mismatch", filePath)
+	}
+
+	return envelope, nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSaveLoadVolumeDataRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-volume-data")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := map[string]string{"volumeId": "vol-1", "driverName": "test.csi.k8s.io"}
+	if err := saveVolumeData(dir, "vol_data.json", data); err != nil {
+		t.Fatalf("saveVolumeData failed: %v", err)
+	}
+
+	loaded, err := loadVolumeData(dir, "vol_data.json")
+	if err != nil {
+		t.Fatalf("loadVolumeData failed: %v", err)
+	}
+	for k, v := range data {
+		if loaded[k] != v {
+			t.Errorf("loaded[%s] = %q, want %q", k, loaded[k], v)
+		}
+	}
+
+	if _, err := os.Stat(path.Join(dir, "vol_data.json.tmp")); !os.IsNotExist(err) {
+		t.Errorf("expected temporary file to be removed by the rename, stat err = %v", err)
+	}
+}
+
+func TestLoadVolumeDataFallsBackToBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-volume-data")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := map[string]string{"volumeId": "vol-1"}
+	if err := saveVolumeData(dir, "vol_data.json", good); err != nil {
+		t.Fatalf("saveVolumeData failed: %v", err)
+	}
+	// a second save produces the ".bak" copy of the first, still-good write
+	if err := saveVolumeData(dir, "vol_data.json", map[string]string{"volumeId": "vol-1-updated"}); err != nil {
+		t.Fatalf("saveVolumeData failed: %v", err)
+	}
+
+	// simulate a kubelet crash mid-write: truncate the primary file
+	dataFilePath := path.Join(dir, "vol_data.json")
+	if err := ioutil.WriteFile(dataFilePath, []byte(`{"version":1,"checksu`), 0644); err != nil {
+		t.Fatalf("failed to truncate volume data file: %v", err)
+	}
+
+	loaded, err := loadVolumeData(dir, "vol_data.json")
+	if err != nil {
+		t.Fatalf("loadVolumeData should have recovered from the backup, got: %v", err)
+	}
+	if loaded["volumeId"] != "vol-1" {
+		t.Errorf("loadVolumeData returned %v, want the backed-up pre-update data", loaded)
+	}
+}
+
+func TestLoadVolumeDataReadsLegacyFlatMapFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-volume-data")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// pre-envelope kubelets wrote the data file as a bare map[string]string
+	dataFilePath := path.Join(dir, "vol_data.json")
+	if err := ioutil.WriteFile(dataFilePath, []byte(`{"volumeId":"vol-1","driverName":"test.csi.k8s.io"}`), 0644); err != nil {
+		t.Fatalf("failed to write legacy volume data file: %v", err)
+	}
+
+	loaded, err := loadVolumeData(dir, "vol_data.json")
+	if err != nil {
+		t.Fatalf("loadVolumeData should accept the pre-envelope legacy format, got: %v", err)
+	}
+	if loaded["volumeId"] != "vol-1" || loaded["driverName"] != "test.csi.k8s.io" {
+		t.Errorf("loadVolumeData returned %v, want the legacy file's data", loaded)
+	}
+}
+
+func TestLoadVolumeDataDetectsCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-volume-data")
+	if err != nil {
+		t.Fatalf("failed to create tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := saveVolumeData(dir, "vol_data.json", map[string]string{"volumeId": "vol-1"}); err != nil {
+		t.Fatalf("saveVolumeData failed: %v", err)
+	}
+
+	dataFilePath := path.Join(dir, "vol_data.json")
+	if err := ioutil.WriteFile(dataFilePath, []byte(`{"version":1,"checksum":"sha256:deadbeef","data":{"volumeId":"tampered"}}`), 0644); err != nil {
+		t.Fatalf("failed to tamper with volume data file: %v", err)
+	}
+
+	if _, err := loadVolumeData(dir, "vol_data.json"); err == nil {
+		t.Errorf("expected loadVolumeData to reject a checksum mismatch with no backup available")
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/prometheus/client_golang/prometheus"
+
+	api "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// envDisableSecretCache lets drivers that require every CSI RPC to see
+	// a freshly read Secret opt out of caching entirely.
+	envDisableSecretCache = "CSI_DISABLE_SECRET_CACHE"
+
+	defaultSecretCacheTTL      = 5 * time.Minute
+	defaultSecretCacheMaxItems = 1000
+)
+
+var (
+	secretCacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: "csi",
+		Name:      "secret_cache_hits_total",
+		Help:      "Number of CSI secret/credential lookups served from the secretCache",
+	})
+	secretCacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: "csi",
+		Name:      "secret_cache_misses_total",
+		Help:      "Number of CSI secret/credential lookups that were not found in the secretCache",
+	})
+	secretCacheEvictionsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Subsystem: "csi",
+		Name:      "secret_cache_evictions_total",
+		Help:      "Number of secretCache entries evicted, by TTL expiry, informer invalidation or LRU pressure",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(secretCacheHitsTotal, secretCacheMissesTotal, secretCacheEvictionsTotal)
+}
+
+// secretCacheEntry is a cached, already-resolved credentials map together
+// with the time it becomes stale.
+type secretCacheEntry struct {
+	credentials map[string]string
+	expiresAt   time.Time
+}
+
+// secretCache is a bounded, TTL-expiring cache of resolved Secret
+// credentials, keyed by "{namespace}/{name}/{resourceVersion}". Entries are
+// also removed immediately when an informer observes the underlying Secret
+// change, so callers never have to wait out the TTL to see an update.
+type secretCache struct {
+	ttl   time.Duration
+	items *lru.Cache
+	mu    sync.Mutex
+}
+
+func newSecretCache(ttl time.Duration, maxItems int) (*secretCache, error) {
+	items, err := lru.NewWithEvict(maxItems, func(key interface{}, value interface{}) {
+		secretCacheEvictionsTotal.Inc()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &secretCache{ttl: ttl, items: items}, nil
+}
+
+func secretCacheKey(namespace, name, resourceVersion string) string {
+	return fmt.Sprintf("%s/%s/%s", namespace, name, resourceVersion)
+}
+
+func (c *secretCache) get(key string) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.items.Get(key)
+	if !ok {
+		return nil, false
+	}
+	entry := v.(*secretCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.items.Remove(key)
+		return nil, false
+	}
+	return entry.credentials, true
+}
+
+func (c *secretCache) set(key string, credentials map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items.Add(key, &secretCacheEntry{
+		credentials: credentials,
+		expiresAt:   time.Now().Add(c.ttl),
+	})
+}
+
+// invalidate drops every cached entry for namespace/name regardless of the
+// resourceVersion suffix, so an update observed through the informer takes
+// effect on the very next lookup.
+func (c *secretCache) invalidate(namespace, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := namespace + "/" + name + "/"
+	for _, key := range c.items.Keys() {
+		if k, ok := key.(string); ok && strings.HasPrefix(k, prefix) {
+			c.items.Remove(k)
+		}
+	}
+}
+
+// cachingCredentialProvider is the CredentialProvider EnableSecretCache
+// installs in place of the built-in secret provider. It reads the Secret
+// through lister -- informer's local, watch-backed store -- rather than the
+// API server, and builds credentials directly from the Secret it already
+// has in hand: a cache hit costs no live API call, and a cache miss costs at
+// most the single live Get needed when the informer hasn't yet observed the
+// Secret (envDisableSecretCache forces that same single live Get on every
+// call).
+type cachingCredentialProvider struct {
+	cache  *secretCache
+	lister corelisters.SecretLister
+}
+
+// newCachingCredentialProvider returns a cachingCredentialProvider backed by
+// informer: Secret updates and deletes observed by informer invalidate the
+// corresponding cache entries immediately.
+func newCachingCredentialProvider(informer coreinformers.SecretInformer, ttl time.Duration, maxItems int) (*cachingCredentialProvider, error) {
+	if ttl <= 0 {
+		ttl = defaultSecretCacheTTL
+	}
+	if maxItems <= 0 {
+		maxItems = defaultSecretCacheMaxItems
+	}
+
+	sc, err := newSecretCache(ttl, maxItems)
+	if err != nil {
+		return nil, err
+	}
+	p := &cachingCredentialProvider{cache: sc, lister: informer.Lister()}
+
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if secret, ok := newObj.(*api.Secret); ok {
+				p.cache.invalidate(secret.Namespace, secret.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if secret, ok := obj.(*api.Secret); ok {
+				p.cache.invalidate(secret.Namespace, secret.Name)
+				return
+			}
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				if secret, ok := tombstone.Obj.(*api.Secret); ok {
+					p.cache.invalidate(secret.Namespace, secret.Name)
+				}
+			}
+		},
+	})
+
+	return p, nil
+}
+
+func (p *cachingCredentialProvider) GetCredentials(k8s kubernetes.Interface, secretRef *api.SecretReference, volumeAttributes map[string]string) (map[string]string, error) {
+	if os.Getenv(envDisableSecretCache) != "" {
+		secret, err := k8s.CoreV1().Secrets(secretRef.Namespace).Get(secretRef.Name, meta.GetOptions{})
+		if err != nil {
+			klog.Errorf("failed to find the secret %s in the namespace %s with error: %v\n", secretRef.Name, secretRef.Namespace, err)
+			return nil, err
+		}
+		return credentialsFromSecret(secret), nil
+	}
+
+	// Read through the informer's local store, not the API server: this is
+	// what makes a cache hit free of any live call. A lister miss means the
+	// informer hasn't (yet) observed this Secret, so fall back to a single
+	// live read rather than fail the mount outright.
+	secret, err := p.lister.Secrets(secretRef.Namespace).Get(secretRef.Name)
+	if err != nil {
+		secret, err = k8s.CoreV1().Secrets(secretRef.Namespace).Get(secretRef.Name, meta.GetOptions{})
+		if err != nil {
+			klog.Errorf("failed to find the secret %s in the namespace %s with error: %v\n", secretRef.Name, secretRef.Namespace, err)
+			return nil, err
+		}
+	}
+
+	key := secretCacheKey(secretRef.Namespace, secretRef.Name, secret.ResourceVersion)
+	if credentials, ok := p.cache.get(key); ok {
+		secretCacheHitsTotal.Inc()
+		return credentials, nil
+	}
+	secretCacheMissesTotal.Inc()
+
+	// secret is already the full object, whether it came from the lister or
+	// the fallback live Get above, so credentials are built from it directly
+	// rather than re-fetching through another CredentialProvider.
+	credentials := credentialsFromSecret(secret)
+	p.cache.set(key, credentials)
+	return credentials, nil
+}
+
+// EnableSecretCache wraps the built-in "secret" CredentialProvider with a
+// TTL+LRU secretCache backed by informer, so repeated attach/mount calls for
+// the same Secret don't each hit the API server. It's meant to be called
+// once, during kubelet/CSI plugin initialization; stopCh is used to bound
+// how long it waits for informer to sync with testInformerSyncPeriod /
+// testInformerSyncTimeout as the poll interval and overall deadline.
+func EnableSecretCache(stopCh <-chan struct{}, informer coreinformers.SecretInformer, ttl time.Duration, maxItems int) error {
+	if err := wait.PollImmediate(testInformerSyncPeriod, testInformerSyncTimeout, func() (bool, error) {
+		select {
+		case <-stopCh:
+			return false, fmt.Errorf("stopped waiting for the secret informer to sync")
+		default:
+		}
+		return informer.Informer().HasSynced(), nil
+	}); err != nil {
+		return fmt.Errorf("csi: secret informer did not sync: %v", err)
+	}
+
+	credentialProvidersLock.Lock()
+	defer credentialProvidersLock.Unlock()
+
+	if _, ok := credentialProviders[defaultCredentialProviderName]; !ok {
+		return fmt.Errorf("csi: no %q credential provider registered to cache", defaultCredentialProviderName)
+	}
+	cached, err := newCachingCredentialProvider(informer, ttl, maxItems)
+	if err != nil {
+		return err
+	}
+	credentialProviders[defaultCredentialProviderName] = cached
+	return nil
+}
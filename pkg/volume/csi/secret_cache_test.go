@@ -0,0 +1,85 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSecretCacheGetSet(t *testing.T) {
+	sc, err := newSecretCache(time.Minute, 10)
+	if err != nil {
+		t.Fatalf("newSecretCache failed: %v", err)
+	}
+
+	key := secretCacheKey("ns", "name", "1")
+	if _, ok := sc.get(key); ok {
+		t.Fatalf("expected miss on an empty cache")
+	}
+
+	want := map[string]string{"user": "alice"}
+	sc.set(key, want)
+	got, ok := sc.get(key)
+	if !ok {
+		t.Fatalf("expected hit after set")
+	}
+	if got["user"] != want["user"] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSecretCacheExpiry(t *testing.T) {
+	sc, err := newSecretCache(time.Millisecond, 10)
+	if err != nil {
+		t.Fatalf("newSecretCache failed: %v", err)
+	}
+
+	key := secretCacheKey("ns", "name", "1")
+	sc.set(key, map[string]string{"user": "alice"})
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := sc.get(key); ok {
+		t.Errorf("expected entry to have expired")
+	}
+}
+
+func TestSecretCacheInvalidate(t *testing.T) {
+	sc, err := newSecretCache(time.Minute, 10)
+	if err != nil {
+		t.Fatalf("newSecretCache failed: %v", err)
+	}
+
+	oldKey := secretCacheKey("ns", "name", "1")
+	newKey := secretCacheKey("ns", "name", "2")
+	otherKey := secretCacheKey("ns", "other", "1")
+	sc.set(oldKey, map[string]string{"user": "alice"})
+	sc.set(newKey, map[string]string{"user": "alice-updated"})
+	sc.set(otherKey, map[string]string{"user": "bob"})
+
+	sc.invalidate("ns", "name")
+
+	if _, ok := sc.get(oldKey); ok {
+		t.Errorf("expected %s to be invalidated", oldKey)
+	}
+	if _, ok := sc.get(newKey); ok {
+		t.Errorf("expected %s to be invalidated", newKey)
+	}
+	if _, ok := sc.get(otherKey); !ok {
+		t.Errorf("expected %s to be unaffected by invalidating a different secret", otherKey)
+	}
+}